@@ -0,0 +1,256 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Package opentelemetry implements the OpenTelemetry stats.Handler for the
+// standard gRPC RED (rate/errors/duration) metric set. It turns the
+// stats.InPayload/OutPayload/End events emitted by gRPC into OpenTelemetry
+// metric instruments; other events are ignored.
+package opentelemetry // import "google.golang.org/grpc/stats/opentelemetry"
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+// MethodFilter controls which methods get per-method metrics recorded for
+// them. It returns true if the method should be recorded under its own
+// name; other methods are recorded under the method name "other".
+type MethodFilter func(method string) bool
+
+// Option configures the metrics produced by NewServerHandler/NewClientHandler.
+type Option func(*options)
+
+type options struct {
+	methodFilter   MethodFilter
+	durationBounds []float64
+	sizeBounds     []float64
+}
+
+// WithMethodFilter returns an Option that sets the filter used to decide
+// which methods are broken out by name in the recorded metrics. Methods
+// for which f returns false are recorded under the method name "other",
+// bounding attribute cardinality. The default filter records every
+// method.
+func WithMethodFilter(f MethodFilter) Option {
+	return func(o *options) { o.methodFilter = f }
+}
+
+// WithDurationHistogramBounds returns an Option that overrides the default
+// bucket boundaries (in seconds) used for the RPC duration histograms.
+func WithDurationHistogramBounds(bounds []float64) Option {
+	return func(o *options) { o.durationBounds = bounds }
+}
+
+// WithSizeHistogramBounds returns an Option that overrides the default
+// bucket boundaries (in bytes) used for the request/response size
+// histograms.
+func WithSizeHistogramBounds(bounds []float64) Option {
+	return func(o *options) { o.sizeBounds = bounds }
+}
+
+var (
+	defaultDurationBounds = []float64{0, 0.00001, 0.00005, 0.0001, 0.0003, 0.001, 0.003, 0.01, 0.03, 0.1, 0.3, 1, 3, 10}
+	defaultSizeBounds     = []float64{0, 1024, 2048, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+)
+
+func resolveOptions(opts []Option) *options {
+	o := &options{
+		methodFilter:   func(string) bool { return true },
+		durationBounds: defaultDurationBounds,
+		sizeBounds:     defaultSizeBounds,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// instruments holds the metric instruments shared by every RPC handled by
+// a single handler instance.
+type instruments struct {
+	duration        metric.Float64Histogram
+	requestSize     metric.Int64Histogram
+	responseSize    metric.Int64Histogram
+	requestsPerRPC  metric.Int64Histogram
+	responsesPerRPC metric.Int64Histogram
+}
+
+func newInstruments(meter metric.Meter, o *options, prefix string) (*instruments, error) {
+	var (
+		in  instruments
+		err error
+	)
+	if in.duration, err = meter.Float64Histogram(prefix+".duration",
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(o.durationBounds...)); err != nil {
+		return nil, err
+	}
+	if in.requestSize, err = meter.Int64Histogram(prefix+".request.size",
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(o.sizeBounds...)); err != nil {
+		return nil, err
+	}
+	if in.responseSize, err = meter.Int64Histogram(prefix+".response.size",
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(o.sizeBounds...)); err != nil {
+		return nil, err
+	}
+	if in.requestsPerRPC, err = meter.Int64Histogram(prefix + ".requests_per_rpc"); err != nil {
+		return nil, err
+	}
+	if in.responsesPerRPC, err = meter.Int64Histogram(prefix + ".responses_per_rpc"); err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// rpcAttempt accumulates the per-RPC state needed to emit metrics at End;
+// it is stashed in the context by TagRPC via rpcAttemptKey.
+type rpcAttempt struct {
+	method    string
+	mu        sync.Mutex
+	reqCount  int64
+	respCount int64
+	reqBytes  int64
+	respBytes int64
+}
+
+type rpcAttemptKey struct{}
+
+func (in *instruments) tagRPC(ctx context.Context, info *stats.RPCTagInfo, filter MethodFilter) context.Context {
+	method := info.FullMethodName
+	if !filter(method) {
+		method = "other"
+	}
+	return context.WithValue(ctx, rpcAttemptKey{}, &rpcAttempt{method: method})
+}
+
+func (in *instruments) handleRPC(ctx context.Context, s stats.RPCStats, isClient bool) {
+	a, _ := ctx.Value(rpcAttemptKey{}).(*rpcAttempt)
+	if a == nil {
+		return
+	}
+	switch st := s.(type) {
+	case *stats.OutPayload:
+		// For a client, an OutPayload is the request it sends; for a
+		// server, it's the response it sends back.
+		a.mu.Lock()
+		if isClient {
+			a.reqCount++
+			a.reqBytes += int64(st.WireLength)
+		} else {
+			a.respCount++
+			a.respBytes += int64(st.WireLength)
+		}
+		a.mu.Unlock()
+	case *stats.InPayload:
+		// For a client, an InPayload is the response it receives; for a
+		// server, it's the request it received.
+		a.mu.Lock()
+		if isClient {
+			a.respCount++
+			a.respBytes += int64(st.WireLength)
+		} else {
+			a.reqCount++
+			a.reqBytes += int64(st.WireLength)
+		}
+		a.mu.Unlock()
+	case *stats.End:
+		dur := st.EndTime.Sub(st.BeginTime).Seconds()
+		statusAttr := metric.WithAttributes(
+			attribute.String("grpc.method", a.method),
+			attribute.String("grpc.status", grpc.Code(st.Error).String()),
+		)
+		in.duration.Record(ctx, dur, statusAttr)
+		a.mu.Lock()
+		reqCount, respCount, reqBytes, respBytes := a.reqCount, a.respCount, a.reqBytes, a.respBytes
+		a.mu.Unlock()
+		methodAttr := metric.WithAttributes(attribute.String("grpc.method", a.method))
+		in.requestSize.Record(ctx, reqBytes, methodAttr)
+		in.responseSize.Record(ctx, respBytes, methodAttr)
+		in.requestsPerRPC.Record(ctx, reqCount, methodAttr)
+		in.responsesPerRPC.Record(ctx, respCount, methodAttr)
+	}
+}
+
+type handler struct {
+	in       *instruments
+	filter   MethodFilter
+	isClient bool
+}
+
+func (h *handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return h.in.tagRPC(ctx, info, h.filter)
+}
+
+func (h *handler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	h.in.handleRPC(ctx, s, h.isClient)
+}
+
+func (h *handler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context { return ctx }
+
+func (h *handler) HandleConn(ctx context.Context, s stats.ConnStats) {}
+
+// NewServerHandler returns a stats.Handler that records the standard gRPC
+// server RED metrics (rpc.server.duration, rpc.server.request.size,
+// rpc.server.response.size, rpc.server.requests_per_rpc,
+// rpc.server.responses_per_rpc) to meter. Attach it to a server via
+// grpc.StatsHandler.
+func NewServerHandler(meter metric.Meter, opts ...Option) (stats.Handler, error) {
+	o := resolveOptions(opts)
+	in, err := newInstruments(meter, o, "rpc.server")
+	if err != nil {
+		return nil, err
+	}
+	return &handler{in: in, filter: o.methodFilter, isClient: false}, nil
+}
+
+// NewClientHandler returns a stats.Handler that records the standard gRPC
+// client RED metrics (rpc.client.duration, rpc.client.request.size,
+// rpc.client.response.size, rpc.client.requests_per_rpc,
+// rpc.client.responses_per_rpc) to meter. Attach it to a ClientConn via
+// grpc.WithStatsHandler.
+func NewClientHandler(meter metric.Meter, opts ...Option) (stats.Handler, error) {
+	o := resolveOptions(opts)
+	in, err := newInstruments(meter, o, "rpc.client")
+	if err != nil {
+		return nil, err
+	}
+	return &handler{in: in, filter: o.methodFilter, isClient: true}, nil
+}
@@ -0,0 +1,270 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package opentelemetry_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/stats/grpc_testing"
+	"google.golang.org/grpc/stats/opentelemetry"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type testServer struct {
+	unaryErr error
+	// streamErr is the status FullDuplexCall ends with once the client has
+	// closed its side of the stream. Nil means end with OK.
+	streamErr error
+
+	// respondLimit caps how many received messages FullDuplexCall echoes
+	// a response for, so tests can drive an asymmetric number of
+	// requests vs. responses. Zero means respond to every message.
+	respondLimit int
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, in *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if s.unaryErr != nil {
+		return nil, s.unaryErr
+	}
+	return &testpb.SimpleResponse{Id: in.Id}, nil
+}
+
+func (s *testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	received := 0
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return s.streamErr
+		}
+		if err != nil {
+			return err
+		}
+		received++
+		if s.respondLimit != 0 && received > s.respondLimit {
+			continue
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Id: in.Id}); err != nil {
+			return err
+		}
+	}
+}
+
+// startServer starts ts with the given ServerOptions applied and returns a
+// client connected to it.
+func startServer(t *testing.T, ts *testServer, opts ...grpc.ServerOption) (testpb.TestServiceClient, func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(opts...)
+	testpb.RegisterTestServiceServer(s, ts)
+	go s.Serve(lis)
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", lis.Addr().String(), err)
+	}
+	return testpb.NewTestServiceClient(cc), func() {
+		cc.Close()
+		s.Stop()
+	}
+}
+
+// metricByName finds the metric with the given name among the scope's
+// metrics, failing the test if it is not present.
+func metricByName(t *testing.T, rm metricdata.ResourceMetrics, name string) metricdata.Metrics {
+	t.Helper()
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("metric %q not found in %+v", name, rm)
+	return metricdata.Metrics{}
+}
+
+// TestServerMetricsUnaryRPCFailingStatus makes a unary RPC that fails with a
+// non-OK status, then asserts the recorded rpc.server.duration histogram has
+// a single data point keyed by the RPC's status code.
+func TestServerMetricsUnaryRPCFailingStatus(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meter := provider.Meter("opentelemetry_test")
+
+	h, err := opentelemetry.NewServerHandler(meter)
+	if err != nil {
+		t.Fatalf("NewServerHandler() = %v", err)
+	}
+	ts := &testServer{unaryErr: grpc.Errorf(codes.ResourceExhausted, "intentional failure")}
+	tc, teardown := startServer(t, ts, grpc.StatsHandler(h))
+	defer teardown()
+
+	if _, err := tc.UnaryCall(context.Background(), &testpb.SimpleRequest{Id: 1}); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("UnaryCall() error = %v, want code %v", err, codes.ResourceExhausted)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect() = %v", err)
+	}
+
+	durHist := metricByName(t, rm, "rpc.server.duration").Data.(metricdata.Histogram[float64])
+	if got := len(durHist.DataPoints); got != 1 {
+		t.Fatalf("rpc.server.duration has %v data points, want 1", got)
+	}
+	dp := durHist.DataPoints[0]
+	if dp.Count != 1 {
+		t.Fatalf("rpc.server.duration count = %v, want 1", dp.Count)
+	}
+	if dp.Sum <= 0 {
+		t.Fatalf("rpc.server.duration sum = %v, want > 0", dp.Sum)
+	}
+	wantStatus, _ := dp.Attributes.Value("grpc.status")
+	if wantStatus.AsString() != codes.ResourceExhausted.String() {
+		t.Fatalf("rpc.server.duration grpc.status attribute = %v, want %v", wantStatus.AsString(), codes.ResourceExhausted)
+	}
+}
+
+// TestServerMetricsStreamingRPCFailingStatus makes a streaming RPC that ends
+// with a non-OK status and asserts the same duration histogram behavior for
+// the streaming path.
+func TestServerMetricsStreamingRPCFailingStatus(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meter := provider.Meter("opentelemetry_test")
+
+	h, err := opentelemetry.NewServerHandler(meter)
+	if err != nil {
+		t.Fatalf("NewServerHandler() = %v", err)
+	}
+	ts := &testServer{streamErr: grpc.Errorf(codes.ResourceExhausted, "intentional failure")}
+	tc, teardown := startServer(t, ts, grpc.StatsHandler(h))
+	defer teardown()
+
+	stream, err := tc.FullDuplexCall(context.Background())
+	if err != nil {
+		t.Fatalf("FullDuplexCall(_) = _, %v", err)
+	}
+	if err := stream.Send(&testpb.SimpleRequest{Id: 1}); err != nil {
+		t.Fatalf("stream.Send(_) = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("stream.Recv() = _, %v", err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("stream.Recv() = _, %v, want code %v", err, codes.ResourceExhausted)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect() = %v", err)
+	}
+
+	durHist := metricByName(t, rm, "rpc.server.duration").Data.(metricdata.Histogram[float64])
+	if got := len(durHist.DataPoints); got != 1 {
+		t.Fatalf("rpc.server.duration has %v data points, want 1", got)
+	}
+	dp := durHist.DataPoints[0]
+	wantStatus, _ := dp.Attributes.Value("grpc.status")
+	if wantStatus.AsString() != codes.ResourceExhausted.String() {
+		t.Fatalf("rpc.server.duration grpc.status attribute = %v, want %v", wantStatus.AsString(), codes.ResourceExhausted)
+	}
+
+	reqSize := metricByName(t, rm, "rpc.server.requests_per_rpc").Data.(metricdata.Histogram[int64])
+	if got := reqSize.DataPoints[0].Sum; got != 1 {
+		t.Fatalf("rpc.server.requests_per_rpc sum = %v, want 1", got)
+	}
+}
+
+// TestServerMetricsStreamingRPCAsymmetricCounts drives a streaming RPC where
+// the client sends two messages but the server only echoes one response,
+// and asserts requests_per_rpc and responses_per_rpc independently. This
+// would have caught the request/response side being swapped for a server
+// handler, since with only one request/response pair in flight the two
+// counts are indistinguishable.
+func TestServerMetricsStreamingRPCAsymmetricCounts(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meter := provider.Meter("opentelemetry_test")
+
+	h, err := opentelemetry.NewServerHandler(meter)
+	if err != nil {
+		t.Fatalf("NewServerHandler() = %v", err)
+	}
+	ts := &testServer{respondLimit: 1}
+	tc, teardown := startServer(t, ts, grpc.StatsHandler(h))
+	defer teardown()
+
+	stream, err := tc.FullDuplexCall(context.Background())
+	if err != nil {
+		t.Fatalf("FullDuplexCall(_) = _, %v", err)
+	}
+	if err := stream.Send(&testpb.SimpleRequest{Id: 1}); err != nil {
+		t.Fatalf("stream.Send(_) = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("stream.Recv() = _, %v", err)
+	}
+	if err := stream.Send(&testpb.SimpleRequest{Id: 2}); err != nil {
+		t.Fatalf("stream.Send(_) = %v", err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream.Recv() = _, %v, want io.EOF", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect() = %v", err)
+	}
+
+	reqPerRPC := metricByName(t, rm, "rpc.server.requests_per_rpc").Data.(metricdata.Histogram[int64])
+	if got := reqPerRPC.DataPoints[0].Sum; got != 2 {
+		t.Fatalf("rpc.server.requests_per_rpc sum = %v, want 2 (the server received 2 requests)", got)
+	}
+	respPerRPC := metricByName(t, rm, "rpc.server.responses_per_rpc").Data.(metricdata.Histogram[int64])
+	if got := respPerRPC.DataPoints[0].Sum; got != 1 {
+		t.Fatalf("rpc.server.responses_per_rpc sum = %v, want 1 (the server sent 1 response)", got)
+	}
+}
@@ -0,0 +1,417 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Package stats is for collecting and reporting various network and RPC
+// stats. This package is for monitoring purpose only. All fields are
+// read-only. All APIs are experimental.
+package stats // import "google.golang.org/grpc/stats"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// RPCStats contains stats information about RPCs.
+type RPCStats interface {
+	isRPCStats()
+	// IsClient returns true if this RPCStats is from client side.
+	IsClient() bool
+}
+
+// ConnStats contains stats information about connections.
+type ConnStats interface {
+	isConnStats()
+	// IsClient returns true if this ConnStats is from client side.
+	IsClient() bool
+}
+
+// InHeader contains stats when a header is received.
+type InHeader struct {
+	// Client is true if this InHeader is from client side.
+	Client bool
+	// WireLength is the wire length of header.
+	WireLength int
+
+	// The following fields are valid only if Client is false.
+	// FullMethod is the full RPC method string, i.e., /package.service/method.
+	FullMethod string
+	// RemoteAddr is the remote address of the corresponding connection.
+	RemoteAddr net.Addr
+	// LocalAddr is the local address of the corresponding connection.
+	LocalAddr net.Addr
+	// Compression is the compression algorithm used for the RPC.
+	Compression string
+
+	// Method and Encryption are kept for backward compatibility with the
+	// field names used before per-connection tagging was introduced.
+	Method     string
+	Encryption string
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *InHeader) IsClient() bool { return s.Client }
+
+func (s *InHeader) isRPCStats() {}
+
+// InPayload contains the information for an incoming payload.
+type InPayload struct {
+	// Client is true if this InPayload is from client side.
+	Client bool
+	// Payload is the payload with original type.
+	Payload interface{}
+	// Data is the serialized message payload.
+	Data []byte
+	// Length is the size of the uncompressed payload data. Does not include
+	// any framing (gRPC or HTTP/2).
+	Length int
+	// CompressedLength is the size of the payload after compression, and
+	// before gRPC framing is applied. It is equal to Length when no
+	// compressor is configured for the RPC.
+	CompressedLength int
+	// WireLength is the number of bytes this payload consumed on the wire,
+	// after compression (if any) and gRPC framing.
+	WireLength int
+	// RecvTime is the time when the payload is received.
+	RecvTime time.Time
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *InPayload) IsClient() bool { return s.Client }
+
+func (s *InPayload) isRPCStats() {}
+
+// InTrailer contains stats when a trailer is received.
+type InTrailer struct {
+	// Client is true if this InTrailer is from client side.
+	Client bool
+	// WireLength is the wire length of trailer.
+	WireLength int
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *InTrailer) IsClient() bool { return s.Client }
+
+func (s *InTrailer) isRPCStats() {}
+
+// OutHeader contains stats when a header is sent.
+type OutHeader struct {
+	// Client is true if this OutHeader is from client side.
+	Client bool
+	// WireLength is the wire length of header.
+	WireLength int
+
+	// The following fields are valid only if Client is true.
+	FullMethod  string
+	RemoteAddr  net.Addr
+	LocalAddr   net.Addr
+	Compression string
+
+	Method     string
+	Encryption string
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *OutHeader) IsClient() bool { return s.Client }
+
+func (s *OutHeader) isRPCStats() {}
+
+// OutPayload contains the information for an outgoing payload.
+type OutPayload struct {
+	// Client is true if this OutPayload is from client side.
+	Client bool
+	// Payload is the payload with original type.
+	Payload interface{}
+	// Data is the serialized message payload.
+	Data []byte
+	// Length is the size of the uncompressed payload data.
+	Length int
+	// CompressedLength is the size of the payload after compression, and
+	// before gRPC framing is applied. It is equal to Length when no
+	// compressor is configured for the RPC.
+	CompressedLength int
+	// WireLength is the number of bytes this payload consumed on the wire.
+	WireLength int
+	// SentTime is the time when the payload is sent.
+	SentTime time.Time
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *OutPayload) IsClient() bool { return s.Client }
+
+func (s *OutPayload) isRPCStats() {}
+
+// OutTrailer contains stats when a trailer is sent.
+type OutTrailer struct {
+	// Client is true if this OutTrailer is from client side.
+	Client bool
+	// WireLength is the wire length of trailer.
+	WireLength int
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *OutTrailer) IsClient() bool { return s.Client }
+
+func (s *OutTrailer) isRPCStats() {}
+
+// RPCErr contains the error that happened during an RPC. It is kept for
+// backward compatibility with Handlers written against the previous,
+// error-only terminal event; new Handlers should key off End instead.
+type RPCErr struct {
+	// Client is true if this RPCErr is from client side.
+	Client bool
+	// Error is the error just happened. It implies the RPC has ended.
+	Error error
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *RPCErr) IsClient() bool { return s.Client }
+
+func (s *RPCErr) isRPCStats() {}
+
+// Begin contains stats when an RPC begins. It is always the first stats
+// event for an RPC.
+type Begin struct {
+	// Client is true if this Begin is from client side.
+	Client bool
+	// BeginTime is the time when the RPC begins.
+	BeginTime time.Time
+	// FailFast indicates if this RPC is failfast.
+	FailFast bool
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *Begin) IsClient() bool { return s.Client }
+
+func (s *Begin) isRPCStats() {}
+
+// End contains stats when an RPC ends. It is always the last stats event
+// for an RPC, and subsumes the information carried by RPCErr; Error is nil
+// for an RPC that completed successfully.
+type End struct {
+	// Client is true if this End is from client side.
+	Client bool
+	// BeginTime is the time when the RPC began.
+	BeginTime time.Time
+	// EndTime is the time when the RPC ends.
+	EndTime time.Time
+	// Error is the error the RPC ended with, or nil if it succeeded.
+	Error error
+}
+
+// IsClient indicates if the stats information is from client side.
+func (s *End) IsClient() bool { return s.Client }
+
+func (s *End) isRPCStats() {}
+
+// ConnBegin contains stats when a connection is established. It is always
+// the first ConnStats event delivered for a connection.
+type ConnBegin struct {
+	// Client is true if this ConnBegin is from client side.
+	Client bool
+}
+
+// IsClient indicates if this ConnBegin is from client side.
+func (s *ConnBegin) IsClient() bool { return s.Client }
+
+func (s *ConnBegin) isConnStats() {}
+
+// ConnEnd contains stats when a connection is closed. It is always the
+// last ConnStats event delivered for a connection.
+type ConnEnd struct {
+	// Client is true if this ConnEnd is from client side.
+	Client bool
+}
+
+// IsClient indicates if this ConnEnd is from client side.
+func (s *ConnEnd) IsClient() bool { return s.Client }
+
+func (s *ConnEnd) isConnStats() {}
+
+// RPCTagInfo defines the relevant information needed by RPC context tagging.
+type RPCTagInfo struct {
+	// FullMethodName is the RPC method in the format of
+	// /package.service/method.
+	FullMethodName string
+}
+
+// ConnTagInfo defines the relevant information needed by connection context
+// tagging.
+type ConnTagInfo struct {
+	// RemoteAddr is the remote address of the corresponding connection.
+	RemoteAddr net.Addr
+	// LocalAddr is the local address of the corresponding connection.
+	LocalAddr net.Addr
+	// Protocol is the application protocol negotiated for the connection,
+	// e.g. "h2". It is empty if no protocol negotiation took place.
+	Protocol string
+}
+
+// KeepalivePing contains stats when a keepalive ping is sent or acked on a
+// connection by the HTTP/2 transport.
+type KeepalivePing struct {
+	// Client is true if this KeepalivePing is from client side.
+	Client bool
+}
+
+// IsClient indicates if this KeepalivePing is from client side.
+func (s *KeepalivePing) IsClient() bool { return s.Client }
+
+func (s *KeepalivePing) isConnStats() {}
+
+// KeepaliveTimeout contains stats when a connection is closed by the HTTP/2
+// transport because a keepalive ping went unacknowledged for too long.
+type KeepaliveTimeout struct {
+	// Client is true if this KeepaliveTimeout is from client side.
+	Client bool
+}
+
+// IsClient indicates if this KeepaliveTimeout is from client side.
+func (s *KeepaliveTimeout) IsClient() bool { return s.Client }
+
+func (s *KeepaliveTimeout) isConnStats() {}
+
+// GoAwayReason explains why a GOAWAY frame was sent or received.
+type GoAwayReason uint8
+
+const (
+	// GoAwayNoReason is sent when the GOAWAY has no specific reason, e.g.
+	// when a server is gracefully shutting down.
+	GoAwayNoReason GoAwayReason = iota
+	// GoAwayTooManyPings is sent when the peer violated the keepalive
+	// enforcement policy by sending keepalive pings too frequently.
+	GoAwayTooManyPings
+)
+
+// GoAway contains stats when a GOAWAY frame is sent or received on a
+// connection by the HTTP/2 transport.
+type GoAway struct {
+	// Client is true if this GoAway is from client side.
+	Client bool
+	// Reason is why the GOAWAY was sent.
+	Reason GoAwayReason
+	// DebugData is the debug data sent along with the GOAWAY frame.
+	DebugData []byte
+}
+
+// IsClient indicates if this GoAway is from client side.
+func (s *GoAway) IsClient() bool { return s.Client }
+
+func (s *GoAway) isConnStats() {}
+
+// TLSHandshakeBegin contains stats when a gRPC connection begins its TLS
+// handshake. It is emitted by the credentials layer before the underlying
+// tls.Conn completes negotiation.
+type TLSHandshakeBegin struct {
+	// Client is true if this TLSHandshakeBegin is from client side.
+	Client bool
+}
+
+// IsClient indicates if this TLSHandshakeBegin is from client side.
+func (s *TLSHandshakeBegin) IsClient() bool { return s.Client }
+
+func (s *TLSHandshakeBegin) isConnStats() {}
+
+// TLSHandshakeEnd contains stats when a gRPC connection's TLS handshake
+// completes, successfully or not.
+type TLSHandshakeEnd struct {
+	// Client is true if this TLSHandshakeEnd is from client side.
+	Client bool
+	// Version is the negotiated TLS version, e.g. tls.VersionTLS13.
+	Version uint16
+	// CipherSuite is the negotiated cipher suite. See CipherSuiteName.
+	CipherSuite uint16
+	// ServerName is the SNI server name sent by the client.
+	ServerName string
+	// PeerCertificates is the certificate chain presented by the peer, as
+	// populated by tls.ConnectionState.PeerCertificates.
+	PeerCertificates []*x509.Certificate
+	// Err is the error the handshake failed with, or nil on success.
+	Err error
+	// Duration is how long the handshake took.
+	Duration time.Duration
+}
+
+// IsClient indicates if this TLSHandshakeEnd is from client side.
+func (s *TLSHandshakeEnd) IsClient() bool { return s.Client }
+
+func (s *TLSHandshakeEnd) isConnStats() {}
+
+// CipherSuiteName returns the IANA name for cipher suite id, e.g.
+// "TLS_AES_128_GCM_SHA256"; for an id it does not know it returns the id in
+// hexadecimal, e.g. "0x00FF".
+// It exists so Handlers can report a negotiated CipherSuite without
+// reimplementing the IANA mapping themselves.
+func CipherSuiteName(id uint16) string {
+	return tls.CipherSuiteName(id)
+}
+
+// Handler defines the interface for the related stats handling (e.g.,
+// logging, monitoring) that is triggered by the gRPC library.
+//
+// A Handler is attached to a server via the grpc.StatsHandler ServerOption,
+// or to a client via the grpc.WithStatsHandler DialOption. Multiple handlers may be attached to the same
+// server or client; they are invoked in registration order and see the same
+// stream of events.
+type Handler interface {
+	// TagRPC can attach some information to the given context. The context
+	// used for the rest lifetime of the RPC will be derived from the
+	// returned context.
+	TagRPC(ctx context.Context, info *RPCTagInfo) context.Context
+	// HandleRPC processes the RPC stats.
+	HandleRPC(ctx context.Context, stats RPCStats)
+
+	// TagConn can attach some information to the given context. The
+	// context used in HandleConn for this connection will be derived from
+	// the returned context.
+	TagConn(ctx context.Context, info *ConnTagInfo) context.Context
+	// HandleConn processes the Conn stats.
+	HandleConn(ctx context.Context, stats ConnStats)
+}
+
+// Filter is an interface a Handler may additionally implement to opt out
+// of events for specific RPCs. This is useful for high-cardinality
+// services where per-RPC stats would otherwise be prohibitively
+// expensive to record.
+//
+// Before dispatching events for an RPC, the gRPC library type-asserts
+// each registered Handler to Filter; if present, Filter is called once
+// with the RPCTagInfo for that RPC, and the Handler is skipped for the
+// lifetime of the RPC when it returns false. Handlers that do not
+// implement Filter are always enabled.
+type Filter interface {
+	Filter(info *RPCTagInfo) bool
+}
@@ -34,6 +34,8 @@
 package stats_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"net"
@@ -49,16 +51,67 @@ import (
 	testpb "google.golang.org/grpc/stats/grpc_testing"
 )
 
-func TestStartStop(t *testing.T) {
-	stats.RegisterHandler(nil)
-	defer stats.Stop() // Stop stats in the case of the first Fatalf.
-	if stats.On() != true {
-		t.Fatalf("after start.RegisterCallBack(_), stats.On() = false, want true")
-	}
-	stats.Stop()
-	if stats.On() != false {
-		t.Fatalf("after start.Stop(), stats.On() = false, want true")
-	}
+// testStatsHandler is a stats.Handler that records every RPCStats it
+// observes. Attaching two independent instances to the same server or
+// client lets tests verify that handlers don't interfere with each other.
+type testStatsHandler struct {
+	mu  sync.Mutex
+	got []*gotData
+}
+
+func (h *testStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *testStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {}
+
+func (h *testStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *testStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.got = append(h.got, &gotData{ctx, s.IsClient(), s})
+}
+
+// connStatsHandler is a stats.Handler that records only the ConnStats
+// events it observes (ConnBegin, ConnEnd, GoAway, ...), ignoring RPCStats.
+type connStatsHandler struct {
+	mu  sync.Mutex
+	got []stats.ConnStats
+}
+
+func (h *connStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *connStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.got = append(h.got, s)
+}
+
+func (h *connStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *connStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {}
+
+func (h *connStatsHandler) result() []stats.ConnStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	got := make([]stats.ConnStats, len(h.got))
+	copy(got, h.got)
+	return got
+}
+
+func (h *testStatsHandler) result() []*gotData {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	got := make([]*gotData, len(h.got))
+	copy(got, h.got)
+	return got
 }
 
 var (
@@ -139,6 +192,13 @@ type test struct {
 	srv     *grpc.Server
 	srvAddr string
 
+	// serverStatsHandlers and clientStatsHandlers are attached to the
+	// server and ClientConn (respectively) created by this test, in
+	// registration order. Tests populate these before calling
+	// startServer/clientConn.
+	serverStatsHandlers []stats.Handler
+	clientStatsHandlers []stats.Handler
+
 	cc *grpc.ClientConn // nil until requested via clientConn
 }
 
@@ -178,6 +238,9 @@ func (te *test) startServer(ts testpb.TestServiceServer) {
 			grpc.RPCDecompressor(grpc.NewGZIPDecompressor()),
 		)
 	}
+	for _, h := range te.serverStatsHandlers {
+		opts = append(opts, grpc.StatsHandler(h))
+	}
 	s := grpc.NewServer(opts...)
 	te.srv = s
 	if te.testServer != nil {
@@ -204,6 +267,9 @@ func (te *test) clientConn() *grpc.ClientConn {
 			grpc.WithDecompressor(grpc.NewGZIPDecompressor()),
 		)
 	}
+	for _, h := range te.clientStatsHandlers {
+		opts = append(opts, grpc.WithStatsHandler(h))
+	}
 
 	var err error
 	te.cc, err = grpc.Dial(te.srvAddr, opts...)
@@ -283,6 +349,7 @@ type expectedData struct {
 	respIdx    int
 	responses  []*testpb.SimpleResponse
 	err        error
+	failFast   bool
 }
 
 type gotData struct {
@@ -300,8 +367,38 @@ const (
 	outheader
 	outtrailer
 	errors
+	begins
+	ends
 )
 
+// msgHeaderLen is the size, in bytes, of the length-prefixed message header
+// gRPC puts on the wire ahead of every message: a 1-byte compressed-flag
+// followed by a 4-byte big-endian message length.
+const msgHeaderLen = 5
+
+// grpcStatusTrailerName is the HTTP/2 trailer key gRPC uses to carry the
+// status code; it is present on every trailer block, success or failure,
+// giving InTrailer/OutTrailer's WireLength a known lower bound.
+const grpcStatusTrailerName = "grpc-status"
+
+// wireLength returns the number of bytes b would occupy on the wire once
+// compressed (if compress is non-empty) and framed with the gRPC message
+// header.
+func wireLength(b []byte, compress string) (compressed, wire int) {
+	if compress == "" {
+		return len(b), msgHeaderLen + len(b)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Len(), msgHeaderLen + buf.Len()
+}
+
 func checkInHeader(t *testing.T, d *gotData, e *expectedData) {
 	var (
 		ok bool
@@ -313,9 +410,8 @@ func checkInHeader(t *testing.T, d *gotData, e *expectedData) {
 	if d.ctx == nil {
 		t.Fatalf("d.ctx = nil, want <non-nil>")
 	}
-	// TODO check real length, not just > 0.
 	if st.WireLength <= 0 {
-		t.Fatalf("st.Lenght = 0, want > 0")
+		t.Fatalf("st.WireLength = %v, want > 0", st.WireLength)
 	}
 	if !d.client {
 		if st.Method != e.method {
@@ -327,6 +423,11 @@ func checkInHeader(t *testing.T, d *gotData, e *expectedData) {
 		if st.Encryption != e.encryption {
 			t.Fatalf("st.Encryption = %v, want %v", st.Encryption, e.encryption)
 		}
+		// The HPACK-encoded header block can never be shorter than the
+		// method name it carries.
+		if st.WireLength < len(st.Method) {
+			t.Fatalf("st.WireLength = %v, want >= len(st.Method) (%v)", st.WireLength, len(st.Method))
+		}
 	}
 }
 
@@ -356,6 +457,13 @@ func checkInPayload(t *testing.T, d *gotData, e *expectedData) {
 		if st.Length != len(b) {
 			t.Fatalf("st.Lenght = %v, want %v", st.Length, len(b))
 		}
+		wantCompressed, wantWire := wireLength(b, e.encryption)
+		if st.CompressedLength != wantCompressed {
+			t.Fatalf("st.CompressedLength = %v, want %v", st.CompressedLength, wantCompressed)
+		}
+		if st.WireLength != wantWire {
+			t.Fatalf("st.WireLength = %v, want %v", st.WireLength, wantWire)
+		}
 	} else {
 		b, err := proto.Marshal(e.requests[e.reqIdx])
 		if err != nil {
@@ -371,8 +479,14 @@ func checkInPayload(t *testing.T, d *gotData, e *expectedData) {
 		if st.Length != len(b) {
 			t.Fatalf("st.Lenght = %v, want %v", st.Length, len(b))
 		}
+		wantCompressed, wantWire := wireLength(b, e.encryption)
+		if st.CompressedLength != wantCompressed {
+			t.Fatalf("st.CompressedLength = %v, want %v", st.CompressedLength, wantCompressed)
+		}
+		if st.WireLength != wantWire {
+			t.Fatalf("st.WireLength = %v, want %v", st.WireLength, wantWire)
+		}
 	}
-	// TODO check WireLength and ReceivedTime.
 	if st.RecvTime.IsZero() {
 		t.Fatalf("st.ReceivedTime = %v, want <non-zero>", st.RecvTime)
 	}
@@ -389,9 +503,13 @@ func checkInTrailer(t *testing.T, d *gotData, e *expectedData) {
 	if d.ctx == nil {
 		t.Fatalf("d.ctx = nil, want <non-nil>")
 	}
-	// TODO check real length, not just > 0.
 	if st.WireLength <= 0 {
-		t.Fatalf("st.Lenght = 0, want > 0")
+		t.Fatalf("st.WireLength = %v, want > 0", st.WireLength)
+	}
+	// Every trailer carries a grpc-status entry, so the HPACK-encoded
+	// block can never be shorter than that key name.
+	if st.WireLength < len(grpcStatusTrailerName) {
+		t.Fatalf("st.WireLength = %v, want >= len(%q) (%v)", st.WireLength, grpcStatusTrailerName, len(grpcStatusTrailerName))
 	}
 }
 
@@ -406,9 +524,8 @@ func checkOutHeader(t *testing.T, d *gotData, e *expectedData) {
 	if d.ctx == nil {
 		t.Fatalf("d.ctx = nil, want <non-nil>")
 	}
-	// TODO check real length, not just > 0.
 	if st.WireLength <= 0 {
-		t.Fatalf("st.Lenght = 0, want > 0")
+		t.Fatalf("st.WireLength = %v, want > 0", st.WireLength)
 	}
 	if d.client {
 		if st.Method != e.method {
@@ -420,6 +537,9 @@ func checkOutHeader(t *testing.T, d *gotData, e *expectedData) {
 		if st.Encryption != e.encryption {
 			t.Fatalf("st.Encryption = %v, want %v", st.Encryption, e.encryption)
 		}
+		if st.WireLength < len(st.Method) {
+			t.Fatalf("st.WireLength = %v, want >= len(st.Method) (%v)", st.WireLength, len(st.Method))
+		}
 	}
 }
 
@@ -449,6 +569,13 @@ func checkOutPayload(t *testing.T, d *gotData, e *expectedData) {
 		if st.Length != len(b) {
 			t.Fatalf("st.Lenght = %v, want %v", st.Length, len(b))
 		}
+		wantCompressed, wantWire := wireLength(b, e.encryption)
+		if st.CompressedLength != wantCompressed {
+			t.Fatalf("st.CompressedLength = %v, want %v", st.CompressedLength, wantCompressed)
+		}
+		if st.WireLength != wantWire {
+			t.Fatalf("st.WireLength = %v, want %v", st.WireLength, wantWire)
+		}
 	} else {
 		b, err := proto.Marshal(e.responses[e.respIdx])
 		if err != nil {
@@ -464,8 +591,14 @@ func checkOutPayload(t *testing.T, d *gotData, e *expectedData) {
 		if st.Length != len(b) {
 			t.Fatalf("st.Lenght = %v, want %v", st.Length, len(b))
 		}
+		wantCompressed, wantWire := wireLength(b, e.encryption)
+		if st.CompressedLength != wantCompressed {
+			t.Fatalf("st.CompressedLength = %v, want %v", st.CompressedLength, wantCompressed)
+		}
+		if st.WireLength != wantWire {
+			t.Fatalf("st.WireLength = %v, want %v", st.WireLength, wantWire)
+		}
 	}
-	// TODO check WireLength and ReceivedTime.
 	if st.SentTime.IsZero() {
 		t.Fatalf("st.SentTime = %v, want <non-zero>", st.SentTime)
 	}
@@ -485,9 +618,13 @@ func checkOutTrailer(t *testing.T, d *gotData, e *expectedData) {
 	if st.Client {
 		t.Fatalf("st IsClient = true, want false")
 	}
-	// TODO check real length, not just > 0.
 	if st.WireLength <= 0 {
-		t.Fatalf("st.Lenght = 0, want > 0")
+		t.Fatalf("st.WireLength = %v, want > 0", st.WireLength)
+	}
+	// Every trailer carries a grpc-status entry, so the HPACK-encoded
+	// block can never be shorter than that key name.
+	if st.WireLength < len(grpcStatusTrailerName) {
+		t.Fatalf("st.WireLength = %v, want >= len(%q) (%v)", st.WireLength, grpcStatusTrailerName, len(grpcStatusTrailerName))
 	}
 }
 
@@ -507,20 +644,54 @@ func checkErrorStats(t *testing.T, d *gotData, e *expectedData) {
 	}
 }
 
-func TestServerStatsUnaryRPC(t *testing.T) {
+func checkBegin(t *testing.T, d *gotData, e *expectedData) {
 	var (
-		mu  sync.Mutex
-		got []*gotData
+		ok bool
+		st *stats.Begin
 	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if !s.IsClient() {
-			got = append(got, &gotData{ctx, false, s})
-		}
-	})
+	if st, ok = d.s.(*stats.Begin); !ok {
+		t.Fatalf("got %T, want Begin", d.s)
+	}
+	if d.ctx == nil {
+		t.Fatalf("d.ctx = nil, want <non-nil>")
+	}
+	if st.BeginTime.IsZero() {
+		t.Fatalf("st.BeginTime = %v, want <non-zero>", st.BeginTime)
+	}
+	if st.FailFast != e.failFast {
+		t.Fatalf("st.FailFast = %v, want %v", st.FailFast, e.failFast)
+	}
+}
+
+func checkEnd(t *testing.T, d *gotData, e *expectedData) {
+	var (
+		ok bool
+		st *stats.End
+	)
+	if st, ok = d.s.(*stats.End); !ok {
+		t.Fatalf("got %T, want End", d.s)
+	}
+	if d.ctx == nil {
+		t.Fatalf("d.ctx = nil, want <non-nil>")
+	}
+	if st.BeginTime.IsZero() {
+		t.Fatalf("st.BeginTime = %v, want <non-zero>", st.BeginTime)
+	}
+	if st.EndTime.IsZero() {
+		t.Fatalf("st.EndTime = %v, want <non-zero>", st.EndTime)
+	}
+	if st.EndTime.Before(st.BeginTime) {
+		t.Fatalf("st.EndTime = %v, want >= st.BeginTime (%v)", st.EndTime, st.BeginTime)
+	}
+	if grpc.Code(st.Error) != grpc.Code(e.err) || grpc.ErrorDesc(st.Error) != grpc.ErrorDesc(e.err) {
+		t.Fatalf("st.Error = %v, want %v", st.Error, e.err)
+	}
+}
 
+func TestServerStatsUnaryRPC(t *testing.T) {
+	h := &testStatsHandler{}
 	te := newTest(t, "")
+	te.serverStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -538,40 +709,30 @@ func TestServerStatsUnaryRPC(t *testing.T) {
 	}
 
 	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
 		checkInHeader,
 		checkInPayload,
 		checkOutHeader,
 		checkOutPayload,
 		checkOutTrailer,
+		checkEnd,
 	}
 
+	got := h.result()
 	if len(got) != len(checkFuncs) {
 		t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
 	}
 
 	for i, f := range checkFuncs {
-		mu.Lock()
 		f(t, got[i], expect)
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestServerStatsUnaryRPCError(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if !s.IsClient() {
-			got = append(got, &gotData{ctx, false, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "")
+	te.serverStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -590,40 +751,30 @@ func TestServerStatsUnaryRPCError(t *testing.T) {
 	}
 
 	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
 		checkInHeader,
 		checkInPayload,
 		checkOutHeader,
 		checkOutTrailer,
 		checkErrorStats,
+		checkEnd,
 	}
 
+	got := h.result()
 	if len(got) != len(checkFuncs) {
 		t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
 	}
 
 	for i, f := range checkFuncs {
-		mu.Lock()
 		f(t, got[i], expect)
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestServerStatsStreamingRPC(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if !s.IsClient() {
-			got = append(got, &gotData{ctx, false, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "gzip")
+	te.serverStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -643,6 +794,7 @@ func TestServerStatsStreamingRPC(t *testing.T) {
 	}
 
 	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
 		checkInHeader,
 		checkOutHeader,
 	}
@@ -653,35 +805,23 @@ func TestServerStatsStreamingRPC(t *testing.T) {
 	for i := 0; i < count; i++ {
 		checkFuncs = append(checkFuncs, ioPayFuncs...)
 	}
-	checkFuncs = append(checkFuncs, checkOutTrailer)
+	checkFuncs = append(checkFuncs, checkOutTrailer, checkEnd)
 
+	got := h.result()
 	if len(got) != len(checkFuncs) {
 		t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
 	}
 
 	for i, f := range checkFuncs {
-		mu.Lock()
 		f(t, got[i], expect)
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestServerStatsStreamingRPCError(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if !s.IsClient() {
-			got = append(got, &gotData{ctx, false, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "gzip")
+	te.serverStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -702,24 +842,24 @@ func TestServerStatsStreamingRPCError(t *testing.T) {
 	}
 
 	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
 		checkInHeader,
 		checkOutHeader,
 		checkInPayload,
 		checkOutTrailer,
 		checkErrorStats,
+		checkEnd,
 	}
 
+	got := h.result()
 	if len(got) != len(checkFuncs) {
 		t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
 	}
 
 	for i, f := range checkFuncs {
-		mu.Lock()
 		f(t, got[i], expect)
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 type checkFuncWithCount struct {
@@ -728,19 +868,9 @@ type checkFuncWithCount struct {
 }
 
 func TestClientStatsUnaryRPC(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if s.IsClient() {
-			got = append(got, &gotData{ctx, true, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "")
+	te.clientStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -758,24 +888,32 @@ func TestClientStatsUnaryRPC(t *testing.T) {
 	}
 
 	checkFuncs := map[int]*checkFuncWithCount{
+		begins:    &checkFuncWithCount{checkBegin, 1},
 		outheader: &checkFuncWithCount{checkOutHeader, 1},
 		outpay:    &checkFuncWithCount{checkOutPayload, 1},
 		inheader:  &checkFuncWithCount{checkInHeader, 1},
 		inpay:     &checkFuncWithCount{checkInPayload, 1},
 		intrailer: &checkFuncWithCount{checkInTrailer, 1},
+		ends:      &checkFuncWithCount{checkEnd, 1},
 	}
 
 	var expectLen int
 	for _, v := range checkFuncs {
 		expectLen += v.c
 	}
+	got := h.result()
 	if len(got) != expectLen {
 		t.Fatalf("got %v stats, want %v stats", len(got), expectLen)
 	}
 
 	for _, s := range got {
-		mu.Lock()
 		switch s.s.(type) {
+		case *stats.Begin:
+			if checkFuncs[begins].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[begins].f(t, s, expect)
+			checkFuncs[begins].c--
 		case *stats.OutHeader:
 			if checkFuncs[outheader].c <= 0 {
 				t.Fatalf("unexpected stats: %T", s)
@@ -806,29 +944,23 @@ func TestClientStatsUnaryRPC(t *testing.T) {
 			}
 			checkFuncs[intrailer].f(t, s, expect)
 			checkFuncs[intrailer].c--
+		case *stats.End:
+			if checkFuncs[ends].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[ends].f(t, s, expect)
+			checkFuncs[ends].c--
 		default:
 			t.Fatalf("unexpected stats: %T", s)
 		}
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestClientStatsUnaryRPCError(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if s.IsClient() {
-			got = append(got, &gotData{ctx, true, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "")
+	te.clientStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -847,40 +979,30 @@ func TestClientStatsUnaryRPCError(t *testing.T) {
 	}
 
 	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
 		checkOutHeader,
 		checkOutPayload,
 		checkInHeader,
 		checkInTrailer,
 		checkErrorStats,
+		checkEnd,
 	}
 
+	got := h.result()
 	if len(got) != len(checkFuncs) {
 		t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
 	}
 
 	for i, f := range checkFuncs {
-		mu.Lock()
 		f(t, got[i], expect)
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestClientStatsStreamingRPC(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if s.IsClient() {
-			got = append(got, &gotData{ctx, true, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "gzip")
+	te.clientStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -900,24 +1022,32 @@ func TestClientStatsStreamingRPC(t *testing.T) {
 	}
 
 	checkFuncs := map[int]*checkFuncWithCount{
+		begins:    &checkFuncWithCount{checkBegin, 1},
 		outheader: &checkFuncWithCount{checkOutHeader, 1},
 		outpay:    &checkFuncWithCount{checkOutPayload, count},
 		inheader:  &checkFuncWithCount{checkInHeader, 1},
 		inpay:     &checkFuncWithCount{checkInPayload, count},
 		intrailer: &checkFuncWithCount{checkInTrailer, 1},
+		ends:      &checkFuncWithCount{checkEnd, 1},
 	}
 
 	var expectLen int
 	for _, v := range checkFuncs {
 		expectLen += v.c
 	}
+	got := h.result()
 	if len(got) != expectLen {
 		t.Fatalf("got %v stats, want %v stats", len(got), expectLen)
 	}
 
 	for _, s := range got {
-		mu.Lock()
 		switch s.s.(type) {
+		case *stats.Begin:
+			if checkFuncs[begins].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[begins].f(t, s, expect)
+			checkFuncs[begins].c--
 		case *stats.OutHeader:
 			if checkFuncs[outheader].c <= 0 {
 				t.Fatalf("unexpected stats: %T", s)
@@ -948,29 +1078,23 @@ func TestClientStatsStreamingRPC(t *testing.T) {
 			}
 			checkFuncs[intrailer].f(t, s, expect)
 			checkFuncs[intrailer].c--
+		case *stats.End:
+			if checkFuncs[ends].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[ends].f(t, s, expect)
+			checkFuncs[ends].c--
 		default:
 			t.Fatalf("unexpected stats: %T", s)
 		}
-		mu.Unlock()
 	}
 
-	stats.Stop()
 }
 
 func TestClientStatsStreamingRPCError(t *testing.T) {
-	var (
-		mu  sync.Mutex
-		got []*gotData
-	)
-	stats.RegisterHandler(func(ctx context.Context, s stats.RPCStats) {
-		mu.Lock()
-		defer mu.Unlock()
-		if s.IsClient() {
-			got = append(got, &gotData{ctx, true, s})
-		}
-	})
-
+	h := &testStatsHandler{}
 	te := newTest(t, "gzip")
+	te.clientStatsHandlers = []stats.Handler{h}
 	te.startServer(&testServer{})
 	defer te.tearDown()
 
@@ -991,24 +1115,32 @@ func TestClientStatsStreamingRPCError(t *testing.T) {
 	}
 
 	checkFuncs := map[int]*checkFuncWithCount{
+		begins:    &checkFuncWithCount{checkBegin, 1},
 		outheader: &checkFuncWithCount{checkOutHeader, 1},
 		outpay:    &checkFuncWithCount{checkOutPayload, 1},
 		inheader:  &checkFuncWithCount{checkInHeader, 1},
 		intrailer: &checkFuncWithCount{checkInTrailer, 1},
 		errors:    &checkFuncWithCount{checkErrorStats, 1},
+		ends:      &checkFuncWithCount{checkEnd, 1},
 	}
 
 	var expectLen int
 	for _, v := range checkFuncs {
 		expectLen += v.c
 	}
+	got := h.result()
 	if len(got) != expectLen {
 		t.Fatalf("got %v stats, want %v stats", len(got), expectLen)
 	}
 
 	for _, s := range got {
-		mu.Lock()
 		switch s.s.(type) {
+		case *stats.Begin:
+			if checkFuncs[begins].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[begins].f(t, s, expect)
+			checkFuncs[begins].c--
 		case *stats.OutHeader:
 			if checkFuncs[outheader].c <= 0 {
 				t.Fatalf("unexpected stats: %T", s)
@@ -1045,11 +1177,137 @@ func TestClientStatsStreamingRPCError(t *testing.T) {
 			}
 			checkFuncs[errors].f(t, s, expect)
 			checkFuncs[errors].c--
+		case *stats.End:
+			if checkFuncs[ends].c <= 0 {
+				t.Fatalf("unexpected stats: %T", s)
+			}
+			checkFuncs[ends].f(t, s, expect)
+			checkFuncs[ends].c--
 		default:
 			t.Fatalf("unexpected stats: %T", s)
 		}
-		mu.Unlock()
 	}
 
-	stats.Stop()
+}
+
+// filteringStatsHandler wraps a testStatsHandler and implements
+// stats.Filter, letting a test opt a handler out of a specific method's
+// events without affecting other handlers attached to the same server.
+type filteringStatsHandler struct {
+	testStatsHandler
+	enabled func(info *stats.RPCTagInfo) bool
+}
+
+func (h *filteringStatsHandler) Filter(info *stats.RPCTagInfo) bool {
+	return h.enabled(info)
+}
+
+func TestServerStatsUnaryRPCTwoHandlers(t *testing.T) {
+	h1 := &testStatsHandler{}
+	h2 := &testStatsHandler{}
+
+	te := newTest(t, "")
+	te.serverStatsHandlers = []stats.Handler{h1, h2}
+	te.startServer(&testServer{})
+	defer te.tearDown()
+
+	req, resp, err := te.doUnaryCall(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	te.srv.GracefulStop() // Wait for the server to stop.
+
+	expect := &expectedData{
+		method:     "/grpc.testing.TestService/UnaryCall",
+		serverAddr: te.srvAddr,
+		requests:   []*testpb.SimpleRequest{req},
+		responses:  []*testpb.SimpleResponse{resp},
+	}
+
+	checkFuncs := []func(t *testing.T, d *gotData, e *expectedData){
+		checkBegin,
+		checkInHeader,
+		checkInPayload,
+		checkOutHeader,
+		checkOutPayload,
+		checkOutTrailer,
+		checkEnd,
+	}
+
+	for _, h := range []*testStatsHandler{h1, h2} {
+		got := h.result()
+		if len(got) != len(checkFuncs) {
+			t.Fatalf("got %v stats, want %v stats", len(got), len(checkFuncs))
+		}
+		for i, f := range checkFuncs {
+			f(t, got[i], expect)
+		}
+	}
+}
+
+func TestServerStatsUnaryRPCFilteredHandler(t *testing.T) {
+	tracked := &testStatsHandler{}
+	filtered := &filteringStatsHandler{
+		enabled: func(info *stats.RPCTagInfo) bool { return false },
+	}
+
+	te := newTest(t, "")
+	te.serverStatsHandlers = []stats.Handler{tracked, filtered}
+	te.startServer(&testServer{})
+	defer te.tearDown()
+
+	if _, _, err := te.doUnaryCall(true); err != nil {
+		t.Fatalf(err.Error())
+	}
+	te.srv.GracefulStop() // Wait for the server to stop.
+
+	if got := tracked.result(); len(got) == 0 {
+		t.Fatalf("tracked handler got no stats, want some")
+	}
+	if got := filtered.result(); len(got) != 0 {
+		t.Fatalf("filtered-out handler got %v stats, want 0", len(got))
+	}
+}
+
+// TestConnStatsGracefulStop makes an RPC over a single connection and then
+// gracefully stops the server, and verifies that exactly one ConnBegin/
+// ConnEnd pair is delivered for the client connection, along with a GoAway
+// event carrying GoAwayNoReason.
+func TestConnStatsGracefulStop(t *testing.T) {
+	h := &connStatsHandler{}
+
+	te := newTest(t, "")
+	te.serverStatsHandlers = []stats.Handler{h}
+	te.startServer(&testServer{})
+	defer te.tearDown()
+
+	if _, _, err := te.doUnaryCall(true); err != nil {
+		t.Fatalf(err.Error())
+	}
+	te.srv.GracefulStop() // Wait for the server to stop.
+
+	got := h.result()
+	var begins, ends, goAways int
+	for _, s := range got {
+		switch e := s.(type) {
+		case *stats.ConnBegin:
+			begins++
+		case *stats.ConnEnd:
+			ends++
+		case *stats.GoAway:
+			goAways++
+			if e.Reason != stats.GoAwayNoReason {
+				t.Fatalf("GoAway.Reason = %v, want %v", e.Reason, stats.GoAwayNoReason)
+			}
+		}
+	}
+	if begins != 1 {
+		t.Fatalf("got %v ConnBegin events, want 1", begins)
+	}
+	if ends != 1 {
+		t.Fatalf("got %v ConnEnd events, want 1", ends)
+	}
+	if goAways != 1 {
+		t.Fatalf("got %v GoAway events, want 1", goAways)
+	}
 }
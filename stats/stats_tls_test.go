@@ -0,0 +1,165 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package stats_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/stats"
+	testpb "google.golang.org/grpc/stats/grpc_testing"
+)
+
+// selfSignedCert returns a fresh, self-signed TLS certificate for
+// "localhost", valid for use by both a test server and, with
+// InsecureSkipVerify, a test client.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() = %v", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+}
+
+// startTLSServer starts a TLS-enabled gRPC server restricted to TLS 1.3,
+// with serverHandler attached as its stats.Handler, and returns a TLS 1.3
+// client, using clientHandler as its stats.Handler, connected to it.
+func startTLSServer(t *testing.T, ts testpb.TestServiceServer, serverHandler, clientHandler stats.Handler) (testpb.TestServiceClient, func()) {
+	t.Helper()
+	cert := selfSignedCert(t)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	serverCreds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		MaxVersion:   tls.VersionTLS13,
+	})
+	s := grpc.NewServer(grpc.Creds(serverCreds), grpc.StatsHandler(serverHandler))
+	testpb.RegisterTestServiceServer(s, ts)
+	go s.Serve(lis)
+
+	clientCreds := credentials.NewTLS(&tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		MaxVersion:         tls.VersionTLS13,
+	})
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(clientCreds), grpc.WithStatsHandler(clientHandler))
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", lis.Addr().String(), err)
+	}
+	return testpb.NewTestServiceClient(cc), func() {
+		cc.Close()
+		s.Stop()
+	}
+}
+
+// TestTLSHandshakeStats makes a unary RPC over a freshly-dialed TLS 1.3
+// connection and asserts that the client connection sees a
+// TLSHandshakeBegin/End pair exactly once, with a non-zero Duration and the
+// negotiated TLS 1.3 cipher reported on the End event.
+func TestTLSHandshakeStats(t *testing.T) {
+	clientHandler := &connStatsHandler{}
+	tc, teardown := startTLSServer(t, &testServer{}, &connStatsHandler{}, clientHandler)
+	defer teardown()
+
+	if _, err := tc.UnaryCall(context.Background(), &testpb.SimpleRequest{Id: 1}); err != nil {
+		t.Fatalf("UnaryCall(_) = _, %v, want <nil>", err)
+	}
+
+	var begins, ends int
+	for _, s := range clientHandler.result() {
+		switch e := s.(type) {
+		case *stats.TLSHandshakeBegin:
+			begins++
+		case *stats.TLSHandshakeEnd:
+			ends++
+			if e.Err != nil {
+				t.Fatalf("TLSHandshakeEnd.Err = %v, want <nil>", e.Err)
+			}
+			if e.Duration <= 0 {
+				t.Fatalf("TLSHandshakeEnd.Duration = %v, want > 0", e.Duration)
+			}
+			if e.Version != tls.VersionTLS13 {
+				t.Fatalf("TLSHandshakeEnd.Version = %x, want %x (TLS 1.3)", e.Version, tls.VersionTLS13)
+			}
+			switch e.CipherSuite {
+			case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256:
+			default:
+				t.Fatalf("CipherSuite = %#04x (%s), want one of the TLS 1.3 suites", e.CipherSuite, stats.CipherSuiteName(e.CipherSuite))
+			}
+		}
+	}
+	if begins != 1 {
+		t.Fatalf("got %v TLSHandshakeBegin events, want 1", begins)
+	}
+	if ends != 1 {
+		t.Fatalf("got %v TLSHandshakeEnd events, want 1", ends)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Package tap defines the function handles which are executed on the
+// transport layer of gRPC-Go and related information. Everything in this
+// package is EXPERIMENTAL.
+package tap // import "google.golang.org/grpc/tap"
+
+import (
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TapInfo defines the relevant information needed by the handlers.
+type TapInfo struct {
+	// FullMethodName is the string of grpc method (in the format of
+	// /package.service/method).
+	FullMethodName string
+	// Header contains the metadata received with the request, i.e. the
+	// client-sent metadata as seen before any service method runs.
+	Header metadata.MD
+	// More to be added.
+}
+
+// ServerInHandle defines the function which runs before a new stream is
+// created on the server side. It runs as soon as the headers for the
+// stream are received, and before any message on the stream, so it can be
+// used to intercept and reject RPCs before the cost of unmarshaling a
+// request message, or before invoking the service method, is paid.
+//
+// The context used by the rest of the stream processing is derived from
+// the returned context; returning a non-nil error fails the RPC with that
+// error without the service method ever being invoked.
+//
+// It is called with a context derived from the context passed to
+// NewServer's Serve method, and with the TagConn context of the
+// connection this stream was established on, if any stats.Handler was
+// configured.
+//
+// The implementation must return quickly; the service method runs only
+// after ServerInHandle returns.
+type ServerInHandle func(ctx context.Context, info *TapInfo) (context.Context, error)
@@ -0,0 +1,232 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package tap_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	testpb "google.golang.org/grpc/stats/grpc_testing"
+	"google.golang.org/grpc/tap"
+)
+
+// rejectID is the request id the tests send on the RPCs they mark for
+// rejection by the tap handle in this file.
+const rejectID int32 = 100
+
+type testServer struct {
+	unaryCalled      bool
+	fullDuplexCalled bool
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, in *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	s.unaryCalled = true
+	return &testpb.SimpleResponse{Id: in.Id}, nil
+}
+
+func (s *testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	s.fullDuplexCalled = true
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Id: in.Id}); err != nil {
+			return err
+		}
+	}
+}
+
+// recordingStatsHandler records the RPCStats it observes so tests can
+// assert that InHeader and a terminal End (with the tap's error, if any)
+// are still delivered in order when a tap rejects the RPC.
+type recordingStatsHandler struct {
+	mu  sync.Mutex
+	got []stats.RPCStats
+}
+
+func (h *recordingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+func (h *recordingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+func (h *recordingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+func (h *recordingStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.got = append(h.got, s)
+}
+func (h *recordingStatsHandler) result() []stats.RPCStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	got := make([]stats.RPCStats, len(h.got))
+	copy(got, h.got)
+	return got
+}
+
+// rejectingTap rejects, with codes.ResourceExhausted, any RPC whose incoming
+// metadata carries "reject: yes", so that it never reaches the service
+// method. It runs before any request message is read.
+func rejectingTap(ctx context.Context, info *tap.TapInfo) (context.Context, error) {
+	if md, ok := metadata.FromContext(ctx); ok {
+		if v := md["reject"]; len(v) > 0 && v[0] == "yes" {
+			return ctx, grpc.Errorf(codes.ResourceExhausted, "rejected by tap: %s", info.FullMethodName)
+		}
+	}
+	return ctx, nil
+}
+
+func startServer(t *testing.T, ts *testServer, h *recordingStatsHandler) (addr string, teardown func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	opts := []grpc.ServerOption{
+		grpc.InTapHandle(rejectingTap),
+		grpc.StatsHandler(h),
+	}
+	s := grpc.NewServer(opts...)
+	testpb.RegisterTestServiceServer(s, ts)
+	go s.Serve(lis)
+	_, port, err := net.SplitHostPort(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to parse listener address: %v", err)
+	}
+	addr = "127.0.0.1:" + port
+	return addr, s.Stop
+}
+
+func TestUnaryCallRejectedByTap(t *testing.T) {
+	ts := &testServer{}
+	h := &recordingStatsHandler{}
+	addr, teardown := startServer(t, ts, h)
+	defer teardown()
+
+	cc, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", addr, err)
+	}
+	defer cc.Close()
+	tc := testpb.NewTestServiceClient(cc)
+
+	ctx := metadata.NewContext(context.Background(), metadata.MD{"reject": []string{"yes"}})
+	_, err = tc.UnaryCall(ctx, &testpb.SimpleRequest{Id: rejectID})
+	if grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("UnaryCall() error = %v, want code %v", err, codes.ResourceExhausted)
+	}
+	if ts.unaryCalled {
+		t.Fatalf("service method UnaryCall was invoked for a tap-rejected RPC")
+	}
+
+	got := h.result()
+	if len(got) != 2 {
+		t.Fatalf("got %v stats events, want 2 (InHeader, End)", len(got))
+	}
+	if _, ok := got[0].(*stats.InHeader); !ok {
+		t.Fatalf("got[0] = %T, want *stats.InHeader", got[0])
+	}
+	end, ok := got[1].(*stats.End)
+	if !ok {
+		t.Fatalf("got[1] = %T, want *stats.End", got[1])
+	}
+	if grpc.Code(end.Error) != codes.ResourceExhausted {
+		t.Fatalf("End.Error = %v, want code %v", end.Error, codes.ResourceExhausted)
+	}
+}
+
+func TestFullDuplexCallRejectedByTap(t *testing.T) {
+	ts := &testServer{}
+	h := &recordingStatsHandler{}
+	addr, teardown := startServer(t, ts, h)
+	defer teardown()
+
+	cc, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", addr, err)
+	}
+	defer cc.Close()
+	tc := testpb.NewTestServiceClient(cc)
+
+	ctx := metadata.NewContext(context.Background(), metadata.MD{"reject": []string{"yes"}})
+	stream, err := tc.FullDuplexCall(ctx)
+	if err != nil {
+		t.Fatalf("FullDuplexCall(_) = _, %v", err)
+	}
+	if err := stream.Send(&testpb.SimpleRequest{Id: rejectID}); err != nil {
+		t.Fatalf("stream.Send(_) = %v", err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("stream.Recv() error = %v, want code %v", err, codes.ResourceExhausted)
+	}
+	if ts.fullDuplexCalled {
+		t.Fatalf("service method FullDuplexCall was invoked for a tap-rejected RPC")
+	}
+}
+
+func TestUnaryCallAllowedByTap(t *testing.T) {
+	ts := &testServer{}
+	h := &recordingStatsHandler{}
+	addr, teardown := startServer(t, ts, h)
+	defer teardown()
+
+	cc, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", addr, err)
+	}
+	defer cc.Close()
+	tc := testpb.NewTestServiceClient(cc)
+
+	resp, err := tc.UnaryCall(context.Background(), &testpb.SimpleRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("UnaryCall(_) = _, %v, want <nil>", err)
+	}
+	if resp.Id != 1 {
+		t.Fatalf("resp.Id = %v, want 1", resp.Id)
+	}
+	if !ts.unaryCalled {
+		t.Fatalf("service method UnaryCall was not invoked for a tap-allowed RPC")
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+// Package lrs produces xDS load reports (LRS, Load Reporting Service) from
+// the stats events gRPC already emits for every RPC. A LoadStore is attached
+// to a ClientConn as a stats.Handler via grpc.WithStatsHandler; the LRS
+// streaming client then drains it on every load report interval via
+// ReportSnapshot.
+package lrs // import "google.golang.org/grpc/xds/lrs"
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3endpointpb "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Locality identifies the locality an upstream endpoint belongs to, as
+// reported by EDS.
+type Locality struct {
+	Region  string
+	Zone    string
+	SubZone string
+}
+
+func (l Locality) key() string { return l.Region + "/" + l.Zone + "/" + l.SubZone }
+
+func (l Locality) proto() *v3corepb.Locality {
+	return &v3corepb.Locality{Region: l.Region, Zone: l.Zone, SubZone: l.SubZone}
+}
+
+type callKey struct{}
+
+// callInfo is stashed in the RPC context by TagRPC, and filled in as the RPC
+// progresses: cluster/locality are known up front, upstream is learned from
+// the OutHeader once the transport has picked a sub-connection.
+type callInfo struct {
+	cluster  string
+	locality Locality
+
+	mu       sync.Mutex
+	upstream string
+	started  bool
+}
+
+// WithLocality returns a context derived from ctx that attributes load
+// reports for RPCs made with it to cluster and locality. It is meant to be
+// called by the picker that selected locality for the RPC.
+func WithLocality(ctx context.Context, cluster string, locality Locality) context.Context {
+	return context.WithValue(ctx, callKey{}, &callInfo{cluster: cluster, locality: locality})
+}
+
+// endpointCounts accumulates the load data for a single (cluster, locality,
+// upstream endpoint) triple.
+type endpointCounts struct {
+	mu sync.Mutex
+
+	started    uint64
+	inProgress uint64
+	succeeded  uint64
+	errored    uint64
+
+	durationCount uint64
+	durationSum   float64
+}
+
+func (c *endpointCounts) callStarted() {
+	c.mu.Lock()
+	c.started++
+	c.inProgress++
+	c.mu.Unlock()
+}
+
+func (c *endpointCounts) callFinished(code codes.Code, dur time.Duration) {
+	c.mu.Lock()
+	c.inProgress--
+	if code == codes.OK {
+		c.succeeded++
+	} else {
+		c.errored++
+	}
+	c.durationCount++
+	c.durationSum += dur.Seconds()
+	c.mu.Unlock()
+}
+
+// snapshot returns the current counts and resets the started/succeeded/
+// errored/duration deltas, leaving inProgress (a gauge, not a delta) intact.
+func (c *endpointCounts) snapshot() (started, inProgress, succeeded, errored, durationCount uint64, durationSum float64) {
+	c.mu.Lock()
+	started, inProgress, succeeded, errored = c.started, c.inProgress, c.succeeded, c.errored
+	durationCount, durationSum = c.durationCount, c.durationSum
+	c.started, c.succeeded, c.errored = 0, 0, 0
+	c.durationCount, c.durationSum = 0, 0
+	c.mu.Unlock()
+	return
+}
+
+type localityCounts struct {
+	locality  Locality
+	endpoints map[string]*endpointCounts
+}
+
+type clusterCounts struct {
+	mu sync.Mutex
+
+	localities map[string]*localityCounts
+
+	totalDropped uint64
+	dropped      map[string]uint64
+}
+
+// LoadStore is a stats.Handler that aggregates per-cluster, per-locality,
+// per-upstream-endpoint RPC counts and latencies for xDS load reporting.
+type LoadStore struct {
+	mu         sync.Mutex
+	clusters   map[string]*clusterCounts
+	lastReport time.Time
+}
+
+// NewLoadStore returns a LoadStore ready to be attached to a ClientConn via
+// grpc.WithStatsHandler.
+func NewLoadStore() *LoadStore {
+	return &LoadStore{clusters: make(map[string]*clusterCounts), lastReport: time.Now()}
+}
+
+func (ls *LoadStore) clusterFor(name string) *clusterCounts {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	cc, ok := ls.clusters[name]
+	if !ok {
+		cc = &clusterCounts{
+			localities: make(map[string]*localityCounts),
+			dropped:    make(map[string]uint64),
+		}
+		ls.clusters[name] = cc
+	}
+	return cc
+}
+
+func (cc *clusterCounts) endpointFor(locality Locality, upstream string) *endpointCounts {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	lc, ok := cc.localities[locality.key()]
+	if !ok {
+		lc = &localityCounts{locality: locality, endpoints: make(map[string]*endpointCounts)}
+		cc.localities[locality.key()] = lc
+	}
+	ec, ok := lc.endpoints[upstream]
+	if !ok {
+		ec = &endpointCounts{}
+		lc.endpoints[upstream] = ec
+	}
+	return ec
+}
+
+// CallDropped records that an RPC for cluster was dropped by the load
+// balancer, before ever being sent, for the given drop category. It is
+// called directly by the picker; dropped calls never reach TagRPC/HandleRPC.
+func (ls *LoadStore) CallDropped(cluster, category string) {
+	cc := ls.clusterFor(cluster)
+	cc.mu.Lock()
+	cc.totalDropped++
+	cc.dropped[category]++
+	cc.mu.Unlock()
+}
+
+// TagRPC returns ctx unchanged: the callInfo stashed by WithLocality, if
+// any, is already in ctx, where HandleRPC finds it for every event in this
+// RPC's lifetime.
+func (ls *LoadStore) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler.
+func (ls *LoadStore) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	ci, ok := ctx.Value(callKey{}).(*callInfo)
+	if !ok {
+		return
+	}
+	switch st := s.(type) {
+	case *stats.Begin:
+		// The upstream endpoint isn't resolved yet at Begin time (OutHeader
+		// is what learns it, once the transport has picked a
+		// sub-connection); recording callStarted here would bucket it under
+		// the empty-upstream key while the matching callFinished at End
+		// lands under the real address, splitting the two across different
+		// endpointCounts. Defer the started count to OutHeader so both use
+		// the same resolved upstream; see the OutHeader and End cases.
+	case *stats.OutHeader:
+		ci.mu.Lock()
+		if st.RemoteAddr != nil {
+			ci.upstream = st.RemoteAddr.String()
+		}
+		upstream := ci.upstream
+		ci.started = true
+		ci.mu.Unlock()
+		ls.clusterFor(ci.cluster).endpointFor(ci.locality, upstream).callStarted()
+	case *stats.End:
+		ci.mu.Lock()
+		upstream := ci.upstream
+		started := ci.started
+		ci.mu.Unlock()
+		ec := ls.clusterFor(ci.cluster).endpointFor(ci.locality, upstream)
+		if !started {
+			// The RPC never got far enough to send headers (e.g. it failed
+			// during pick), so OutHeader never ran callStarted. Count it
+			// started now, against the same (unresolved) upstream key End
+			// itself is about to use, so the two always agree.
+			ec.callStarted()
+		}
+		ec.callFinished(status.Code(st.Error), st.EndTime.Sub(st.BeginTime))
+	}
+}
+
+// TagConn is a no-op; load reporting is keyed by RPC, not by connection.
+func (ls *LoadStore) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+// HandleConn is a no-op; load reporting is keyed by RPC, not by connection.
+func (ls *LoadStore) HandleConn(context.Context, stats.ConnStats) {}
+
+// ReportSnapshot returns a ClusterStats message per cluster with data since
+// the previous call, ready to be sent on the LRS stream, and clears the
+// started/succeeded/errored/duration/dropped deltas it reports.
+func (ls *LoadStore) ReportSnapshot() []*v3endpointpb.ClusterStats {
+	ls.mu.Lock()
+	names := make([]string, 0, len(ls.clusters))
+	for name := range ls.clusters {
+		names = append(names, name)
+	}
+	now := time.Now()
+	interval := now.Sub(ls.lastReport)
+	ls.lastReport = now
+	ls.mu.Unlock()
+
+	var out []*v3endpointpb.ClusterStats
+	for _, name := range names {
+		cc := ls.clusterFor(name)
+
+		cc.mu.Lock()
+		localities := make([]*localityCounts, 0, len(cc.localities))
+		for _, lc := range cc.localities {
+			localities = append(localities, lc)
+		}
+		totalDropped := cc.totalDropped
+		dropped := make(map[string]uint64, len(cc.dropped))
+		for k, v := range cc.dropped {
+			dropped[k] = v
+		}
+		cc.totalDropped = 0
+		for k := range cc.dropped {
+			cc.dropped[k] = 0
+		}
+		cc.mu.Unlock()
+
+		cs := &v3endpointpb.ClusterStats{
+			ClusterName:          name,
+			TotalDroppedRequests: totalDropped,
+			LoadReportInterval:   durationpb.New(interval),
+		}
+		for category, count := range dropped {
+			if count == 0 {
+				continue
+			}
+			cs.DroppedRequests = append(cs.DroppedRequests, &v3endpointpb.ClusterStats_DroppedRequests{
+				Category:     category,
+				DroppedCount: count,
+			})
+		}
+		for _, lc := range localities {
+			uls := &v3endpointpb.UpstreamLocalityStats{Locality: lc.locality.proto()}
+			var durCount uint64
+			var durSum float64
+			// ClusterStats reports totals per locality; the per-endpoint
+			// breakdown only exists in endpointCounts, summed here.
+			for _, ec := range lc.endpoints {
+				started, inProgress, succeeded, errored, c, s := ec.snapshot()
+				uls.TotalIssuedRequests += started
+				uls.TotalRequestsInProgress += inProgress
+				uls.TotalSuccessfulRequests += succeeded
+				uls.TotalErrorRequests += errored
+				durCount += c
+				durSum += s
+			}
+			if durCount > 0 {
+				uls.LoadMetricStats = append(uls.LoadMetricStats, &v3endpointpb.EndpointLoadMetricStats{
+					MetricName:                    "rpc_latency_seconds",
+					NumRequestsFinishedWithMetric: durCount,
+					TotalMetricValue:              durSum,
+				})
+			}
+			cs.UpstreamLocalityStats = append(cs.UpstreamLocalityStats, uls)
+		}
+		out = append(out, cs)
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+/*
+ *
+ * Copyright 2016, Google Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     * Redistributions of source code must retain the above copyright
+ * notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above
+ * copyright notice, this list of conditions and the following disclaimer
+ * in the documentation and/or other materials provided with the
+ * distribution.
+ *     * Neither the name of Google Inc. nor the names of its
+ * contributors may be used to endorse or promote products derived from
+ * this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ *
+ */
+
+package lrs_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/stats/grpc_testing"
+	"google.golang.org/grpc/xds/lrs"
+
+	v3endpointpb "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+)
+
+const (
+	testCluster = "test-cluster"
+)
+
+var testLocality = lrs.Locality{Region: "region-1", Zone: "zone-1", SubZone: "sub-zone-1"}
+
+type testServer struct {
+	failID int32
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, in *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if in.Id == s.failID {
+		return nil, grpc.Errorf(codes.DeadlineExceeded, "intentional failure")
+	}
+	return &testpb.SimpleResponse{Id: in.Id}, nil
+}
+
+func (s *testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Id: in.Id}); err != nil {
+			return err
+		}
+	}
+}
+
+func startServer(t *testing.T, ts *testServer, h *lrs.LoadStore) (testpb.TestServiceClient, func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer(grpc.StatsHandler(h))
+	testpb.RegisterTestServiceServer(s, ts)
+	go s.Serve(lis)
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(), grpc.WithStatsHandler(h))
+	if err != nil {
+		t.Fatalf("Dial(%q) = %v", lis.Addr().String(), err)
+	}
+	return testpb.NewTestServiceClient(cc), func() {
+		cc.Close()
+		s.Stop()
+	}
+}
+
+// findLocality returns the UpstreamLocalityStats matching testLocality among
+// cs's localities, failing the test if it is missing.
+func findLocality(t *testing.T, cs *v3endpointpb.ClusterStats) *v3endpointpb.UpstreamLocalityStats {
+	t.Helper()
+	for _, uls := range cs.UpstreamLocalityStats {
+		if uls.Locality.GetRegion() == testLocality.Region &&
+			uls.Locality.GetZone() == testLocality.Zone &&
+			uls.Locality.GetSubZone() == testLocality.SubZone {
+			return uls
+		}
+	}
+	t.Fatalf("no UpstreamLocalityStats for locality %+v in %+v", testLocality, cs)
+	return nil
+}
+
+// TestReportSnapshotMixedSuccessAndFailure drives a mix of successful and
+// DeadlineExceeded RPCs against a cluster/locality, plus a simulated drop,
+// and asserts ReportSnapshot's ClusterStats counters and dropped tally.
+func TestReportSnapshotMixedSuccessAndFailure(t *testing.T) {
+	const failID = 2
+	ls := lrs.NewLoadStore()
+	ts := &testServer{failID: failID}
+	tc, teardown := startServer(t, ts, ls)
+	defer teardown()
+
+	ls.CallDropped(testCluster, "load_balancing")
+
+	for id := int32(0); id < 4; id++ {
+		ctx := lrs.WithLocality(context.Background(), testCluster, testLocality)
+		_, err := tc.UnaryCall(ctx, &testpb.SimpleRequest{Id: id})
+		if id == failID {
+			if grpc.Code(err) != codes.DeadlineExceeded {
+				t.Fatalf("UnaryCall(Id: %v) error = %v, want code %v", id, err, codes.DeadlineExceeded)
+			}
+		} else if err != nil {
+			t.Fatalf("UnaryCall(Id: %v) = %v, want <nil>", id, err)
+		}
+	}
+
+	snap := ls.ReportSnapshot()
+	if len(snap) != 1 {
+		t.Fatalf("ReportSnapshot() returned %v ClusterStats, want 1", len(snap))
+	}
+	cs := snap[0]
+	if cs.ClusterName != testCluster {
+		t.Fatalf("ClusterStats.ClusterName = %q, want %q", cs.ClusterName, testCluster)
+	}
+	if cs.TotalDroppedRequests != 1 {
+		t.Fatalf("ClusterStats.TotalDroppedRequests = %v, want 1", cs.TotalDroppedRequests)
+	}
+	if cs.LoadReportInterval.AsDuration() <= 0 {
+		t.Fatalf("ClusterStats.LoadReportInterval = %v, want > 0", cs.LoadReportInterval.AsDuration())
+	}
+	if len(cs.DroppedRequests) != 1 || cs.DroppedRequests[0].Category != "load_balancing" || cs.DroppedRequests[0].DroppedCount != 1 {
+		t.Fatalf("ClusterStats.DroppedRequests = %+v, want one load_balancing drop", cs.DroppedRequests)
+	}
+
+	uls := findLocality(t, cs)
+	if uls.TotalIssuedRequests != 4 {
+		t.Fatalf("TotalIssuedRequests = %v, want 4", uls.TotalIssuedRequests)
+	}
+	if uls.TotalSuccessfulRequests != 3 {
+		t.Fatalf("TotalSuccessfulRequests = %v, want 3", uls.TotalSuccessfulRequests)
+	}
+	if uls.TotalErrorRequests != 1 {
+		t.Fatalf("TotalErrorRequests = %v, want 1", uls.TotalErrorRequests)
+	}
+	if uls.TotalRequestsInProgress != 0 {
+		t.Fatalf("TotalRequestsInProgress = %v, want 0", uls.TotalRequestsInProgress)
+	}
+	if len(uls.LoadMetricStats) != 1 || uls.LoadMetricStats[0].NumRequestsFinishedWithMetric != 4 {
+		t.Fatalf("LoadMetricStats = %+v, want one entry counting 4 finished RPCs", uls.LoadMetricStats)
+	}
+	if uls.LoadMetricStats[0].TotalMetricValue <= 0 {
+		t.Fatalf("LoadMetricStats[0].TotalMetricValue = %v, want > 0", uls.LoadMetricStats[0].TotalMetricValue)
+	}
+
+	// A second snapshot with no further RPCs should report zero deltas,
+	// but the same steady-state zero in-progress gauge.
+	snap2 := ls.ReportSnapshot()
+	if len(snap2) != 1 {
+		t.Fatalf("second ReportSnapshot() returned %v ClusterStats, want 1", len(snap2))
+	}
+	uls2 := findLocality(t, snap2[0])
+	if uls2.TotalIssuedRequests != 0 || uls2.TotalSuccessfulRequests != 0 || uls2.TotalErrorRequests != 0 || uls2.TotalRequestsInProgress != 0 {
+		t.Fatalf("second snapshot locality stats = %+v, want all zero deltas and zero in progress", uls2)
+	}
+}